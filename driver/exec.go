@@ -0,0 +1,55 @@
+/*
+   Copyright (c) 2023 Saglabs SA. All Rights Reserved.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import "os/exec"
+
+// Cmd abstracts the subset of *exec.Cmd that mfsHandler needs, so command
+// execution can be faked in unit tests.
+type Cmd interface {
+	SetDir(dir string)
+	CombinedOutput() ([]byte, error)
+}
+
+// Exec abstracts process creation, mirroring utilexec.Interface from
+// Kubernetes, so mfsHandler doesn't depend on os/exec directly.
+type Exec interface {
+	Command(name string, args ...string) Cmd
+}
+
+type realExec struct{}
+
+// NewExec returns the production Exec implementation.
+func NewExec() Exec {
+	return &realExec{}
+}
+
+func (realExec) Command(name string, args ...string) Cmd {
+	return &realCmd{cmd: exec.Command(name, args...)}
+}
+
+type realCmd struct {
+	cmd *exec.Cmd
+}
+
+func (c *realCmd) SetDir(dir string) {
+	c.cmd.Dir = dir
+}
+
+func (c *realCmd) CombinedOutput() ([]byte, error) {
+	return c.cmd.CombinedOutput()
+}
@@ -0,0 +1,72 @@
+/*
+   Copyright (c) 2023 Saglabs SA. All Rights Reserved.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+// FakeCmdResult scripts one CombinedOutput() response.
+type FakeCmdResult struct {
+	Output []byte
+	Err    error
+}
+
+// FakeExecCall records the arguments a single Command call was made with.
+type FakeExecCall struct {
+	Name string
+	Args []string
+	Dir  string
+}
+
+// FakeExec is an Exec that hands out FakeCmds instead of spawning real
+// processes, for use in unit tests. Results are scripted per command name:
+// each CombinedOutput() call pops the next result queued for that name; if
+// none remain it returns no output and a nil error.
+type FakeExec struct {
+	Results map[string][]FakeCmdResult
+	Calls   []FakeExecCall
+}
+
+// NewFakeExec returns an empty FakeExec.
+func NewFakeExec() *FakeExec {
+	return &FakeExec{Results: map[string][]FakeCmdResult{}}
+}
+
+func (f *FakeExec) Command(name string, args ...string) Cmd {
+	return &FakeCmd{exec: f, name: name, args: args}
+}
+
+// FakeCmd is the Cmd returned by FakeExec.Command.
+type FakeCmd struct {
+	exec *FakeExec
+	name string
+	args []string
+	dir  string
+}
+
+func (c *FakeCmd) SetDir(dir string) {
+	c.dir = dir
+}
+
+func (c *FakeCmd) CombinedOutput() ([]byte, error) {
+	c.exec.Calls = append(c.exec.Calls, FakeExecCall{Name: c.name, Args: c.args, Dir: c.dir})
+
+	results := c.exec.Results[c.name]
+	if len(results) == 0 {
+		return nil, nil
+	}
+	next := results[0]
+	c.exec.Results[c.name] = results[1:]
+	return next.Output, next.Err
+}
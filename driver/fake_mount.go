@@ -0,0 +1,117 @@
+/*
+   Copyright (c) 2023 Saglabs SA. All Rights Reserved.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import "sync"
+
+// FakeMountCall records the arguments a single Mount call was made with.
+type FakeMountCall struct {
+	Source  string
+	Target  string
+	FsType  string
+	Options []string
+}
+
+// FakeMounter is a Mounter that records every call against an in-memory
+// mount table instead of touching the host, for use in unit tests.
+type FakeMounter struct {
+	mu     sync.Mutex
+	Mounts []MountPoint
+
+	MountCalls   []FakeMountCall
+	UnmountCalls []string
+
+	MountErr   error
+	UnmountErr error
+	ListErr    error
+
+	// StatfsResults scripts the Statfs reply for a given path; a path with
+	// no entry gets a zero-valued StatfsResult and no error.
+	StatfsResults map[string]StatfsResult
+	StatfsErr     error
+}
+
+// NewFakeMounter returns an empty FakeMounter.
+func NewFakeMounter() *FakeMounter {
+	return &FakeMounter{}
+}
+
+func (f *FakeMounter) Mount(source, target, fsType string, options ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.MountCalls = append(f.MountCalls, FakeMountCall{Source: source, Target: target, FsType: fsType, Options: options})
+	if f.MountErr != nil {
+		return f.MountErr
+	}
+	f.Mounts = append(f.Mounts, MountPoint{Device: source, Path: target, Type: fsType, Opts: options})
+	return nil
+}
+
+func (f *FakeMounter) Unmount(target string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.UnmountCalls = append(f.UnmountCalls, target)
+	if f.UnmountErr != nil {
+		return f.UnmountErr
+	}
+	for i, mp := range f.Mounts {
+		if mp.Path == target {
+			f.Mounts = append(f.Mounts[:i], f.Mounts[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (f *FakeMounter) IsMounted(target string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.ListErr != nil {
+		return false, f.ListErr
+	}
+	for _, mp := range f.Mounts {
+		if mp.Path == target {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *FakeMounter) List() ([]MountPoint, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.ListErr != nil {
+		return nil, f.ListErr
+	}
+	out := make([]MountPoint, len(f.Mounts))
+	copy(out, f.Mounts)
+	return out, nil
+}
+
+func (f *FakeMounter) Statfs(path string) (StatfsResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.StatfsErr != nil {
+		return StatfsResult{}, f.StatfsErr
+	}
+	return f.StatfsResults[path], nil
+}
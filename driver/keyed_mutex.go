@@ -0,0 +1,69 @@
+/*
+   Copyright (c) 2023 Saglabs SA. All Rights Reserved.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"sort"
+	"sync"
+)
+
+// keyedMutex serializes operations that share a key (a volumeId or a
+// mountpoint) while letting operations on different keys run concurrently.
+// Locks are created lazily and kept for the lifetime of the handler; the
+// number of distinct keys is bounded by the number of volumes/mountpoints
+// a node ever sees, so this does not grow unbounded in practice.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: make(map[string]*sync.Mutex)}
+}
+
+// lock acquires the mutex for key, creating it on first use, and returns
+// an unlock function. Typical use: `defer km.lock(volumeId)()`.
+func (km *keyedMutex) lock(key string) func() {
+	km.mu.Lock()
+	l, ok := km.locks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		km.locks[key] = l
+	}
+	km.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// lockTwo acquires the mutexes for both a and b, in a fixed (lexical) order
+// regardless of call order, so two operations locking the same pair of keys
+// can never deadlock waiting on each other. If a == b, it is locked once.
+// Typical use: `defer km.lockTwo(destId, sourceId)()`.
+func (km *keyedMutex) lockTwo(a, b string) func() {
+	if a == b {
+		return km.lock(a)
+	}
+	keys := []string{a, b}
+	sort.Strings(keys)
+	unlockFirst := km.lock(keys[0])
+	unlockSecond := km.lock(keys[1])
+	return func() {
+		unlockSecond()
+		unlockFirst()
+	}
+}
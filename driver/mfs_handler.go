@@ -21,34 +21,27 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path"
-	"strconv"
-	"strings"
+	"time"
 
+	"github.com/moosefs/moosefs-csi/pkg/mfsproto"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 const (
 	fsType            = "moosefs"
 	newVolumeMode     = 0755
-	getQuotaCmd       = "mfsgetquota"
-	setQuotaCmd       = "mfssetquota"
 	createSnapshotCmd = "mfsmakesnapshot"
 	removeSnapshotCmd = "mfsrmsnapshot"
-	// maybe configurable later
-	quotaLimitType = "-L"
-	quotaLimitRow  = 2
-
-	quotaLimitCol = 3
 
 	logsDirName    = "logs"
 	volumesDirName = "volumes"
 
 	mntDir = "/mnt"
+
+	protoTimeout = 10 * time.Second
 )
 
-// todo(ad): in future possibly add more options (mount options?)
 type mfsHandler struct {
 	mfsmaster      string // mfsmaster address
 	mfsmaster_port int    // mfsmaster port
@@ -56,9 +49,28 @@ type mfsHandler struct {
 	pluginDataPath string // plugin data path (inside rootPath)
 	name           string // handler name
 	hostMountPath  string // host mfs mount path
+
+	// mountOptions are mfsmount/FUSE options applied to MountMfs, e.g. from
+	// a StorageClass's mountOptions. Must pass validateMountOptions.
+	mountOptions []string
+
+	mounter Mounter // mount/unmount/mount-table operations; real or fake
+	exec    Exec    // process creation for mfstools CLIs; real or fake
+
+	// locks serializes state-mutating operations keyed by volumeId (or by
+	// mountpoint for MountMfs/BindMount/BindUMount), so two concurrent CSI
+	// RPCs against the same volume can't race each other's
+	// unmount/remove/mount sequences.
+	locks *keyedMutex
 }
 
-func NewMfsHandler(mfsmaster string, mfsmaster_port int, rootPath, pluginDataPath, name string, num ...int) *mfsHandler {
+// NewMfsHandler constructs a handler. mounter and exec may be nil, in
+// which case the real, host-touching implementations are used; tests pass
+// FakeMounter/FakeExec instead. mountOptions are mfsmount/FUSE options
+// (e.g. from a StorageClass's mountOptions or a PV's MountFlags) applied
+// to every MountMfs call; MountMfs rejects them via validateMountOptions
+// if they aren't whitelisted.
+func NewMfsHandler(mfsmaster string, mfsmaster_port int, rootPath, pluginDataPath, name string, mountOptions []string, mounter Mounter, exec Exec, num ...int) *mfsHandler {
 	var numSufix = ""
 	if len(num) == 2 {
 		if num[0] == 0 && num[1] == 1 {
@@ -70,6 +82,13 @@ func NewMfsHandler(mfsmaster string, mfsmaster_port int, rootPath, pluginDataPat
 		log.Errorf("NewMfsHandler - Unexpected number of arguments: %d; expected 0 or 2", len(num))
 	}
 
+	if mounter == nil {
+		mounter = NewMounter()
+	}
+	if exec == nil {
+		exec = NewExec()
+	}
+
 	return &mfsHandler{
 		mfsmaster:      mfsmaster,
 		mfsmaster_port: mfsmaster_port,
@@ -77,6 +96,10 @@ func NewMfsHandler(mfsmaster string, mfsmaster_port int, rootPath, pluginDataPat
 		pluginDataPath: pluginDataPath,
 		name:           name,
 		hostMountPath:  path.Join(mntDir, fmt.Sprintf("%s%s", name, numSufix)),
+		mountOptions:   mountOptions,
+		mounter:        mounter,
+		exec:           exec,
+		locks:          newKeyedMutex(),
 	}
 }
 
@@ -127,6 +150,8 @@ func (mnt *mfsHandler) CreateMountVolume(volumeId string) error {
 }
 
 func (mnt *mfsHandler) CreateVolume(volumeId string, size int64) (int64, error) {
+	defer mnt.locks.lock(volumeId)()
+
 	path := mnt.HostPathToVolume(volumeId)
 	if err := os.MkdirAll(path, newVolumeMode); err != nil {
 		return 0, err
@@ -142,6 +167,8 @@ func (mnt *mfsHandler) CreateVolume(volumeId string, size int64) (int64, error)
 }
 
 func (mnt *mfsHandler) DeleteVolume(volumeId string) error {
+	defer mnt.locks.lock(volumeId)()
+
 	path := mnt.HostPathToVolume(volumeId)
 	if err := os.RemoveAll(path); err != nil {
 		// todo(ad): fix msg
@@ -153,106 +180,131 @@ func (mnt *mfsHandler) DeleteVolume(volumeId string) error {
 	return nil
 }
 
+// quotaClient returns a fresh mfsproto client for the handler's master.
+// Connections are not reused across calls, same as the exec.Command calls
+// this client replaces.
+func (mnt *mfsHandler) quotaClient() *mfsproto.Client {
+	return mfsproto.NewClient(mnt.mfsmaster, mnt.mfsmaster_port, protoTimeout)
+}
+
+// GetQuota returns the hard size (byte) limit set for volumeId, the only
+// quota class the rest of the driver currently cares about. Use
+// mnt.quotaClient().GetQuota to inspect every class (inodes, length, size,
+// realsize; soft and hard).
 func (mnt *mfsHandler) GetQuota(volumeId string) (int64, error) {
 	log.Infof("GetQuota - volumeId: %s", volumeId)
 
-	path := mnt.MfsPathToVolume(volumeId)
-
-	cmd := exec.Command(getQuotaCmd, path)
-	cmd.Dir = mnt.hostMountPath
-	out, err := cmd.CombinedOutput()
-
+	size, err := mnt.quotaForMfsPath(mnt.MfsPathToVolume(volumeId))
 	if err != nil {
-		return 0, fmt.Errorf("GetQuota: Error while executing command %s %s. Error: %s output: %v", getQuotaCmd, path, err.Error(), string(out))
+		return 0, fmt.Errorf("GetQuota: volume %s: %w", volumeId, err)
 	}
-	if quotaLimit, err := parseMfsQuotaToolsOutput(string(out)); err != nil {
+	return size, nil
+}
+
+// ErrQuotaNotSet is returned by quotaForMfsPath/GetQuota when the master
+// was reached successfully but has no hard size quota configured for the
+// path, as distinct from a failure to reach or talk to the master at all.
+// Callers that treat "no quota" as a fallback condition (e.g. VolumeStats)
+// must check for this specific error via errors.Is rather than treating
+// every error the same way.
+var ErrQuotaNotSet = errors.New("mfsproto: hard size quota is not set")
+
+// quotaForMfsPath returns the hard size (byte) limit set for an arbitrary
+// mfs-relative path, shared by GetQuota (volumes) and the snapshot
+// subsystem (snapshots).
+func (mnt *mfsHandler) quotaForMfsPath(mfsPath string) (int64, error) {
+	limits, err := mnt.quotaClient().GetQuota(mfsPath)
+	if err != nil {
 		return 0, err
-	} else if quotaLimit == -1 {
-		return 0, fmt.Errorf("GetQuota: Quota for volume %s is not set or %s output is incorrect. Output: %s", volumeId, getQuotaCmd, string(out))
-	} else {
-		return quotaLimit, nil
 	}
+	if limits.HardSize == 0 {
+		return 0, fmt.Errorf("%w: %s", ErrQuotaNotSet, mfsPath)
+	}
+	return int64(limits.HardSize), nil
 }
 
+// SetQuota sets the hard size (byte) limit for volumeId and returns the
+// limit the master actually stored.
 func (mnt *mfsHandler) SetQuota(volumeId string, size int64) (int64, error) {
 	log.Infof("SetQuota - volumeId: %s, size: %d", volumeId, size)
 
-	path := mnt.MfsPathToVolume(volumeId)
 	if size <= 0 {
-		return 0, errors.New("SetQuota: size must be positive")
+		return 0, fmt.Errorf("SetQuota: size must be positive, got %d", size)
 	}
-	setQuotaArgs := []string{quotaLimitType, strconv.FormatInt(size, 10), path}
-	cmd := exec.Command(setQuotaCmd, setQuotaArgs...)
-	cmd.Dir = mnt.hostMountPath
-	out, err := cmd.CombinedOutput()
-
+	mfsPath := mnt.MfsPathToVolume(volumeId)
+	limits, err := mnt.quotaClient().SetQuota(mfsPath, mfsproto.QuotaSize, 0, uint64(size))
 	if err != nil {
-		return 0, fmt.Errorf("SetQuota: Error while executing command %s %v. Error: %s output: %v", setQuotaCmd, setQuotaArgs, err.Error(), string(out))
-	}
-	if quotaLimit, err := parseMfsQuotaToolsOutput(string(out)); err != nil {
-		return 0, err
-	} else if quotaLimit == -1 {
-		return 0, fmt.Errorf("SetQuota: Quota for volume %s is not set or %s output is incorrect. Output: %s", volumeId, setQuotaCmd, string(out))
-	} else {
-		return quotaLimit, nil
+		return 0, fmt.Errorf("SetQuota: volume %s: %w", volumeId, err)
 	}
+	return int64(limits.HardSize), nil
 }
 
-func (mnt *mfsHandler) CreateSnapshot(volumeId string, snapshotId string) (int64, error) {
-	volPath := mnt.HostPathToVolume(volumeId)
-	snapPath := mnt.HostPathToVolume(snapshotId)
-	createSnapshotArgs := []string{volPath, snapPath}
-	cmd := exec.Command(createSnapshotCmd, createSnapshotArgs...)
-	cmd.Dir = mnt.hostMountPath
-	_, err := cmd.CombinedOutput()
-
+// VolumeStats reports capacity, usage and inode counts for volumeId, for
+// use by CSI NodeGetVolumeStats. It starts from statfs(2) on the volume's
+// host path and, if volumeId has a hard size quota set, reports the quota
+// as capacityBytes/availableBytes instead of the underlying storage
+// pool's totals, so kubelet enforces and reports against the quota. Used
+// bytes for a quota'd volume come from the master's own per-path
+// FUSE_STATFS, not statfs(2)'s pool-wide numbers, since subtracting a
+// pool-wide used figure from a per-volume quota would make every quota'd
+// volume look nearly full.
+func (mnt *mfsHandler) VolumeStats(volumeId string) (capacityBytes, usedBytes, availableBytes, totalInodes, usedInodes, freeInodes int64, err error) {
+	log.Infof("VolumeStats - volumeId: %s", volumeId)
+
+	fs, err := mnt.mounter.Statfs(mnt.HostPathToVolume(volumeId))
 	if err != nil {
-		return 0, err
+		return 0, 0, 0, 0, 0, 0, fmt.Errorf("VolumeStats: volume %s: %w", volumeId, err)
 	}
 
-	return mnt.GetQuota(snapPath)
-}
+	capacityBytes = fs.TotalBytes
+	availableBytes = fs.AvailBytes
+	quota, qerr := mnt.GetQuota(volumeId)
+	switch {
+	case qerr == nil:
+		volStats, serr := mnt.quotaClient().Statfs(mnt.MfsPathToVolume(volumeId))
+		if serr != nil {
+			return 0, 0, 0, 0, 0, 0, fmt.Errorf("VolumeStats: volume %s: %w", volumeId, serr)
+		}
 
-func parseMfsQuotaToolsOutput(output string) (int64, error) {
-	lines := strings.Split(output, "\n")
-	if len(lines) <= quotaLimitRow {
-		return 0, fmt.Errorf("Error while parsing quota tool output (less rows than expected); output: %s", output)
-	}
-	cols := strings.Split(lines[quotaLimitRow], "|")
-	if len(cols) < 5 {
-		return 0, fmt.Errorf("Error while parsing quota tool output (less columns than expected); output: %s", output)
-	}
-	s := strings.TrimSpace(cols[quotaLimitCol])
-	if s == "-" {
-		return -1, nil // let caller take care of error. May be useful for mount volumes
-	}
-	quotaLimit, err := strconv.ParseInt(s, 10, 64)
-	if err != nil {
-		return 0, err
+		capacityBytes = quota
+		used := int64(volStats.TotalSpace) - int64(volStats.AvailSpace)
+		if availableBytes = quota - used; availableBytes < 0 {
+			availableBytes = 0
+		}
+	case errors.Is(qerr, ErrQuotaNotSet):
+		// No quota configured for this volume; statfs(2)'s pool-wide
+		// numbers above are the best answer we have.
+	default:
+		return 0, 0, 0, 0, 0, 0, fmt.Errorf("VolumeStats: volume %s: %w", volumeId, qerr)
 	}
-	return quotaLimit, nil
+
+	return capacityBytes, capacityBytes - availableBytes, availableBytes, fs.TotalInodes, fs.TotalInodes - fs.FreeInodes, fs.FreeInodes, nil
 }
 
 // Mount mounts mfsclient at speciefied earlier point
 func (mnt *mfsHandler) MountMfs() error {
-	mounter := Mounter{}
+	defer mnt.locks.lock(mnt.hostMountPath)()
+
 	mountSource := fmt.Sprintf("%s:%d:%s", mnt.mfsmaster, mnt.mfsmaster_port, mnt.rootPath)
-	mountOptions := make([]string, 0)
+	mountOptions := mnt.mountOptions
+	if err := validateMountOptions(mountOptions); err != nil {
+		return fmt.Errorf("MountMfs: %w", err)
+	}
 
 	log.Infof("MountMfs - source: %s, target: %s, options: %v", mountSource, mnt.hostMountPath, mountOptions)
 
-	if isMounted, err := mounter.IsMounted(mnt.hostMountPath); err != nil {
+	if isMounted, err := mnt.mounter.IsMounted(mnt.hostMountPath); err != nil {
 		return err
 	} else if isMounted {
 		log.Warnf("MountMfs - Mount found in %s. Unmounting...", mnt.hostMountPath)
-		if err = mounter.UMount(mnt.hostMountPath); err != nil {
+		if err = mnt.mounter.Unmount(mnt.hostMountPath); err != nil {
 			return err
 		}
 	}
 	if err := os.RemoveAll(mnt.hostMountPath); err != nil {
 		return err
 	}
-	if err := mounter.Mount(mountSource, mnt.hostMountPath, fsType, mountOptions...); err != nil {
+	if err := mnt.mounter.Mount(mountSource, mnt.hostMountPath, fsType, mountOptions...); err != nil {
 		return err
 	}
 	log.Infof("MountMfs - Successfully mounted %s to %s", mountSource, mnt.hostMountPath)
@@ -260,13 +312,18 @@ func (mnt *mfsHandler) MountMfs() error {
 }
 
 func (mnt *mfsHandler) BindMount(mfsSource string, target string, options ...string) error {
-	mounter := Mounter{}
+	defer mnt.locks.lock(target)()
+
+	if err := validateMountOptions(options); err != nil {
+		return fmt.Errorf("BindMount: %w", err)
+	}
+
 	source := mnt.HostPathTo(mfsSource)
 	log.Infof("BindMount - source: %s, target: %s, options: %v", source, target, options)
-	if isMounted, err := mounter.IsMounted(target); err != nil {
+	if isMounted, err := mnt.mounter.IsMounted(target); err != nil {
 		return err
 	} else if !isMounted {
-		if err := mounter.Mount(source, target, fsType, append(options, "bind")...); err != nil {
+		if err := mnt.mounter.Mount(source, target, fsType, append(options, "bind")...); err != nil {
 			return err
 		}
 	} else {
@@ -276,12 +333,13 @@ func (mnt *mfsHandler) BindMount(mfsSource string, target string, options ...str
 }
 
 func (mnt *mfsHandler) BindUMount(target string) error {
-	mounter := Mounter{}
+	defer mnt.locks.lock(target)()
+
 	log.Infof("BindUMount - target: %s", target)
-	if mounted, err := mounter.IsMounted(target); err != nil {
+	if mounted, err := mnt.mounter.IsMounted(target); err != nil {
 		return err
 	} else if mounted {
-		if err := mounter.UMount(target); err != nil {
+		if err := mnt.mounter.Unmount(target); err != nil {
 			return err
 		}
 	} else {
@@ -0,0 +1,144 @@
+/*
+   Copyright (c) 2023 Saglabs SA. All Rights Reserved.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"testing"
+)
+
+func newTestHandler(mounter Mounter, exec Exec) *mfsHandler {
+	return NewMfsHandler("mfsmaster", 9421, "/", "plugin-data", "test", nil, mounter, exec)
+}
+
+func TestSetQuota_RejectsNonPositiveSize(t *testing.T) {
+	mnt := newTestHandler(NewFakeMounter(), NewFakeExec())
+
+	for _, size := range []int64{0, -1, -100} {
+		if _, err := mnt.SetQuota("vol-1", size); err == nil {
+			t.Errorf("SetQuota(%d): expected error, got nil", size)
+		}
+	}
+}
+
+func TestMountMfs_RemountsExisting(t *testing.T) {
+	fakeMounter := NewFakeMounter()
+	mnt := newTestHandler(fakeMounter, NewFakeExec())
+
+	// Simulate a stale mount already occupying the target.
+	fakeMounter.Mounts = []MountPoint{{Device: "stale:9421:/", Path: mnt.hostMountPath, Type: fsType}}
+
+	if err := mnt.MountMfs(); err != nil {
+		t.Fatalf("MountMfs: unexpected error: %v", err)
+	}
+
+	if len(fakeMounter.UnmountCalls) != 1 || fakeMounter.UnmountCalls[0] != mnt.hostMountPath {
+		t.Fatalf("expected one Unmount call for %s, got %v", mnt.hostMountPath, fakeMounter.UnmountCalls)
+	}
+	if len(fakeMounter.MountCalls) != 1 {
+		t.Fatalf("expected one Mount call, got %d", len(fakeMounter.MountCalls))
+	}
+	if got := fakeMounter.MountCalls[0].Target; got != mnt.hostMountPath {
+		t.Errorf("expected Mount target %s, got %s", mnt.hostMountPath, got)
+	}
+}
+
+func TestMountMfs_SkipsUnmountWhenNotMounted(t *testing.T) {
+	fakeMounter := NewFakeMounter()
+	mnt := newTestHandler(fakeMounter, NewFakeExec())
+
+	if err := mnt.MountMfs(); err != nil {
+		t.Fatalf("MountMfs: unexpected error: %v", err)
+	}
+
+	if len(fakeMounter.UnmountCalls) != 0 {
+		t.Fatalf("expected no Unmount calls, got %v", fakeMounter.UnmountCalls)
+	}
+	if len(fakeMounter.MountCalls) != 1 {
+		t.Fatalf("expected one Mount call, got %d", len(fakeMounter.MountCalls))
+	}
+}
+
+func TestMountMfs_PassesMountOptions(t *testing.T) {
+	fakeMounter := NewFakeMounter()
+	mnt := NewMfsHandler("mfsmaster", 9421, "/", "plugin-data", "test", []string{"ro", "mfssubfolder=/export"}, fakeMounter, NewFakeExec())
+
+	if err := mnt.MountMfs(); err != nil {
+		t.Fatalf("MountMfs: unexpected error: %v", err)
+	}
+
+	if len(fakeMounter.MountCalls) != 1 {
+		t.Fatalf("expected one Mount call, got %d", len(fakeMounter.MountCalls))
+	}
+	got := fakeMounter.MountCalls[0].Options
+	if len(got) != 2 || got[0] != "ro" || got[1] != "mfssubfolder=/export" {
+		t.Errorf("expected options [ro mfssubfolder=/export], got %v", got)
+	}
+}
+
+func TestMountMfs_RejectsUnknownOption(t *testing.T) {
+	mnt := NewMfsHandler("mfsmaster", 9421, "/", "plugin-data", "test", []string{"rm_rf_root"}, NewFakeMounter(), NewFakeExec())
+
+	if err := mnt.MountMfs(); err == nil {
+		t.Fatal("expected error for non-whitelisted mount option")
+	}
+}
+
+func TestBindMount_RejectsUnknownOption(t *testing.T) {
+	mnt := newTestHandler(NewFakeMounter(), NewFakeExec())
+
+	if err := mnt.BindMount("vol-1", "/target", "rm_rf_root"); err == nil {
+		t.Fatal("expected error for non-whitelisted mount option")
+	}
+}
+
+func TestMountMfs_RejectsCommaSmuggledOption(t *testing.T) {
+	// "mfssubfolder" is whitelisted, but mount -o splits its joined options
+	// on ",", so a value embedding a comma could smuggle in "suid,dev".
+	mnt := NewMfsHandler("mfsmaster", 9421, "/", "plugin-data", "test", []string{"mfssubfolder=/tmp,suid,dev"}, NewFakeMounter(), NewFakeExec())
+
+	if err := mnt.MountMfs(); err == nil {
+		t.Fatal("expected error for comma-smuggled mount option")
+	}
+}
+
+func TestVolumeStats_PropagatesQuotaTransportError(t *testing.T) {
+	fakeMounter := NewFakeMounter()
+	mnt := newTestHandler(fakeMounter, NewFakeExec())
+
+	fakeMounter.StatfsResults = map[string]StatfsResult{
+		mnt.HostPathToVolume("vol-1"): {TotalBytes: 1000, AvailBytes: 400, TotalInodes: 100, FreeInodes: 60},
+	}
+
+	// GetQuota can't reach a real mfsmaster in this test, so this exercises
+	// a genuine transport error, not ErrQuotaNotSet -- VolumeStats must
+	// surface it rather than silently falling back to statfs(2)'s numbers,
+	// which would mask a real mfsmaster outage as "no quota configured".
+	if _, _, _, _, _, _, err := mnt.VolumeStats("vol-1"); err == nil {
+		t.Fatal("expected error when GetQuota can't reach mfsmaster, got nil")
+	}
+}
+
+func TestVolumeStats_StatfsError(t *testing.T) {
+	fakeMounter := NewFakeMounter()
+	fakeMounter.StatfsErr = errors.New("statfs unavailable")
+	mnt := newTestHandler(fakeMounter, NewFakeExec())
+
+	if _, _, _, _, _, _, err := mnt.VolumeStats("vol-1"); err == nil {
+		t.Fatal("expected error when Statfs fails")
+	}
+}
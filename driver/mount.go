@@ -0,0 +1,141 @@
+/*
+   Copyright (c) 2023 Saglabs SA. All Rights Reserved.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// MountPoint describes one line of the system's mount table.
+type MountPoint struct {
+	Device string
+	Path   string
+	Type   string
+	Opts   []string
+}
+
+// StatfsResult is the subset of statfs(2)'s output mfsHandler needs to
+// answer CSI NodeGetVolumeStats: the numbers kubelet turns into
+// kubelet_volume_stats_* metrics and ephemeral-storage enforcement.
+type StatfsResult struct {
+	TotalBytes  int64
+	AvailBytes  int64
+	TotalInodes int64
+	FreeInodes  int64
+}
+
+// Mounter abstracts the mount/unmount/mount-table/statfs operations
+// mfsHandler needs, so it can be exercised in unit tests against a fake
+// instead of a real kernel mount table.
+type Mounter interface {
+	Mount(source, target, fsType string, options ...string) error
+	Unmount(target string) error
+	IsMounted(target string) (bool, error)
+	List() ([]MountPoint, error)
+	Statfs(path string) (StatfsResult, error)
+}
+
+// realMounter implements Mounter against the host's actual mount table via
+// the mount(8)/umount(8) CLIs and /proc/mounts.
+type realMounter struct{}
+
+// NewMounter returns the production Mounter implementation.
+func NewMounter() Mounter {
+	return &realMounter{}
+}
+
+func (m *realMounter) Mount(source, target, fsType string, options ...string) error {
+	args := make([]string, 0, len(options)+4)
+	if fsType != "" {
+		args = append(args, "-t", fsType)
+	}
+	if len(options) > 0 {
+		args = append(args, "-o", strings.Join(options, ","))
+	}
+	args = append(args, source, target)
+
+	out, err := exec.Command("mount", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mount %v: %w: %s", args, err, string(out))
+	}
+	return nil
+}
+
+func (m *realMounter) Unmount(target string) error {
+	out, err := exec.Command("umount", target).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("umount %s: %w: %s", target, err, string(out))
+	}
+	return nil
+}
+
+func (m *realMounter) List() ([]MountPoint, error) {
+	data, err := os.ReadFile("/proc/mounts")
+	if err != nil {
+		return nil, fmt.Errorf("reading /proc/mounts: %w", err)
+	}
+
+	var mounts []MountPoint
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		mounts = append(mounts, MountPoint{
+			Device: fields[0],
+			Path:   fields[1],
+			Type:   fields[2],
+			Opts:   strings.Split(fields[3], ","),
+		})
+	}
+	return mounts, nil
+}
+
+func (m *realMounter) IsMounted(target string) (bool, error) {
+	mounts, err := m.List()
+	if err != nil {
+		return false, err
+	}
+	for _, mp := range mounts {
+		if mp.Path == target {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Statfs reports space and inode usage for path as seen by the kernel,
+// i.e. the same numbers `df path` would show. This is the underlying
+// storage pool's totals, not volumeId's quota; callers that need a
+// quota-aware capacity (VolumeStats) must combine this with GetQuota
+// themselves.
+func (m *realMounter) Statfs(path string) (StatfsResult, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return StatfsResult{}, fmt.Errorf("statfs %s: %w", path, err)
+	}
+	return StatfsResult{
+		TotalBytes:  int64(stat.Blocks) * int64(stat.Bsize),
+		AvailBytes:  int64(stat.Bavail) * int64(stat.Bsize),
+		TotalInodes: int64(stat.Files),
+		FreeInodes:  int64(stat.Ffree),
+	}, nil
+}
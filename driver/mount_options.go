@@ -0,0 +1,71 @@
+/*
+   Copyright (c) 2023 Saglabs SA. All Rights Reserved.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// allowedMountOptionKeys whitelists the mfsmount/FUSE option keys callers
+// may request via a StorageClass's mountOptions or a PersistentVolume's
+// CSI VolumeContext/MountFlags. Anything else is rejected before it ever
+// reaches the mount(8) syscall.
+var allowedMountOptionKeys = map[string]bool{
+	// mfsmount-specific options.
+	"mfssubfolder":       true,
+	"mfspassword":        true,
+	"mfsmd5pass":         true,
+	"mfscachemode":       true,
+	"mfsattrcacheto":     true,
+	"mfsentrycacheto":    true,
+	"mfsdirentrycacheto": true,
+	"mfsioretries":       true,
+
+	// generic mount/FUSE options.
+	"nosuid":              true,
+	"noexec":              true,
+	"nodev":               true,
+	"ro":                  true,
+	"rw":                  true,
+	"allow_other":         true,
+	"default_permissions": true,
+}
+
+// validateMountOptions checks every option against allowedMountOptionKeys
+// and returns an error naming the first unrecognized one. Options may be
+// bare flags ("ro") or key=value pairs ("mfssubfolder=/foo"); only the key
+// is checked against the whitelist. Options are later joined with "," and
+// passed to `mount -o`, which itself splits on "," -- so a value containing
+// a comma (e.g. "mfssubfolder=/tmp,suid,dev") would let unwhitelisted
+// options ride along smuggled inside a whitelisted one. Reject that before
+// ever checking the key.
+func validateMountOptions(options []string) error {
+	for _, opt := range options {
+		if strings.ContainsRune(opt, ',') {
+			return fmt.Errorf("mount option %q must not contain a comma", opt)
+		}
+		key := opt
+		if i := strings.IndexByte(opt, '='); i >= 0 {
+			key = opt[:i]
+		}
+		if !allowedMountOptionKeys[key] {
+			return fmt.Errorf("mount option %q is not allowed", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+/*
+   Copyright (c) 2023 Saglabs SA. All Rights Reserved.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	snapshotsDirName     = "snapshots"
+	snapshotManifestMode = 0644
+)
+
+// SnapshotInfo is the sidecar JSON manifest persisted alongside each
+// snapshot's data tree, recording the fields a CSI CreateSnapshotResponse/
+// ListSnapshotsResponse needs that MooseFS itself doesn't track.
+type SnapshotInfo struct {
+	SnapshotId     string    `json:"snapshot_id"`
+	SourceVolumeId string    `json:"source_volume_id"`
+	SizeBytes      int64     `json:"size_bytes"`
+	CreationTime   time.Time `json:"creation_time"`
+	ReadyToUse     bool      `json:"ready_to_use"`
+}
+
+// HostPathToSnapshot returns the absolute path to snapshotId's data tree
+// on the host mfsclient mountpoint.
+func (mnt *mfsHandler) HostPathToSnapshot(snapshotId string) string {
+	return path.Join(mnt.hostMountPath, mnt.pluginDataPath, snapshotsDirName, snapshotId)
+}
+
+// hostSnapshotsDir returns the absolute path to the shared snapshots/
+// directory that every snapshot's data tree and manifest lives under.
+func (mnt *mfsHandler) hostSnapshotsDir() string {
+	return path.Join(mnt.hostMountPath, mnt.pluginDataPath, snapshotsDirName)
+}
+
+// MfsPathToSnapshot returns snapshotId's path relative to the mfs root,
+// the form mfsproto/mfstools expect.
+func (mnt *mfsHandler) MfsPathToSnapshot(snapshotId string) string {
+	return path.Join(mnt.pluginDataPath, snapshotsDirName, snapshotId)
+}
+
+// hostPathToSnapshotManifest returns the absolute path to snapshotId's
+// sidecar JSON manifest, a sibling of its data tree.
+func (mnt *mfsHandler) hostPathToSnapshotManifest(snapshotId string) string {
+	return path.Join(mnt.hostMountPath, mnt.pluginDataPath, snapshotsDirName, snapshotId+".json")
+}
+
+// cloneTree copies sourcePath onto destPath via mfsmakesnapshot, the same
+// primitive CreateSnapshot, CloneFromSnapshot and CloneVolume all use.
+func (mnt *mfsHandler) cloneTree(sourcePath, destPath string) error {
+	cmd := mnt.exec.Command(createSnapshotCmd, sourcePath, destPath)
+	cmd.SetDir(mnt.hostMountPath)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s %s: %w: %s", createSnapshotCmd, sourcePath, destPath, err, string(out))
+	}
+	return nil
+}
+
+// CreateSnapshot snapshots volumeId via mfsmakesnapshot and records a
+// sidecar manifest under snapshots/<snapshotId>.json with the metadata
+// CSI's CreateSnapshotResponse requires.
+func (mnt *mfsHandler) CreateSnapshot(volumeId string, snapshotId string) (*SnapshotInfo, error) {
+	defer mnt.locks.lock(volumeId)()
+
+	log.Infof("CreateSnapshot - volumeId: %s, snapshotId: %s", volumeId, snapshotId)
+
+	if err := os.MkdirAll(mnt.hostSnapshotsDir(), newVolumeMode); err != nil {
+		return nil, fmt.Errorf("CreateSnapshot: %w", err)
+	}
+
+	if err := mnt.cloneTree(mnt.HostPathToVolume(volumeId), mnt.HostPathToSnapshot(snapshotId)); err != nil {
+		return nil, fmt.Errorf("CreateSnapshot: %w", err)
+	}
+
+	sizeBytes, err := mnt.quotaForMfsPath(mnt.MfsPathToSnapshot(snapshotId))
+	if err != nil {
+		return nil, fmt.Errorf("CreateSnapshot: %w", err)
+	}
+
+	info := &SnapshotInfo{
+		SnapshotId:     snapshotId,
+		SourceVolumeId: volumeId,
+		SizeBytes:      sizeBytes,
+		CreationTime:   time.Now(),
+		ReadyToUse:     true,
+	}
+	if err := mnt.writeSnapshotManifest(info); err != nil {
+		return nil, fmt.Errorf("CreateSnapshot: %w", err)
+	}
+	return info, nil
+}
+
+// DeleteSnapshot removes snapshotId's data tree via mfsrmsnapshot along
+// with its sidecar manifest. It is idempotent: deleting an already-gone
+// snapshot is not an error.
+func (mnt *mfsHandler) DeleteSnapshot(snapshotId string) error {
+	defer mnt.locks.lock(snapshotId)()
+
+	log.Infof("DeleteSnapshot - snapshotId: %s", snapshotId)
+
+	snapPath := mnt.HostPathToSnapshot(snapshotId)
+	if _, err := os.Stat(snapPath); err == nil {
+		cmd := mnt.exec.Command(removeSnapshotCmd, snapPath)
+		cmd.SetDir(mnt.hostMountPath)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("DeleteSnapshot: %s %s: %w: %s", removeSnapshotCmd, snapPath, err, string(out))
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("DeleteSnapshot: %w", err)
+	}
+
+	if err := os.Remove(mnt.hostPathToSnapshotManifest(snapshotId)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("DeleteSnapshot: removing manifest for %s: %w", snapshotId, err)
+	}
+	return nil
+}
+
+// writeSnapshotManifest persists info as info.SnapshotId's sidecar JSON
+// manifest.
+func (mnt *mfsHandler) writeSnapshotManifest(info *SnapshotInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("marshal snapshot manifest for %s: %w", info.SnapshotId, err)
+	}
+	manifestPath := mnt.hostPathToSnapshotManifest(info.SnapshotId)
+	if err := os.MkdirAll(path.Dir(manifestPath), newVolumeMode); err != nil {
+		return fmt.Errorf("create snapshots dir for %s: %w", info.SnapshotId, err)
+	}
+	if err := os.WriteFile(manifestPath, data, snapshotManifestMode); err != nil {
+		return fmt.Errorf("write snapshot manifest for %s: %w", info.SnapshotId, err)
+	}
+	return nil
+}
+
+// readSnapshotManifest loads snapshotId's sidecar JSON manifest.
+func (mnt *mfsHandler) readSnapshotManifest(snapshotId string) (*SnapshotInfo, error) {
+	data, err := os.ReadFile(mnt.hostPathToSnapshotManifest(snapshotId))
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot manifest for %s: %w", snapshotId, err)
+	}
+	var info SnapshotInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("unmarshal snapshot manifest for %s: %w", snapshotId, err)
+	}
+	return &info, nil
+}
+
+// ListSnapshots returns up to maxEntries snapshot manifests, ordered by
+// snapshotId, resuming after startingToken (an opaque value previously
+// returned as nextToken) as the CSI ListSnapshots pagination contract
+// requires. maxEntries <= 0 means unlimited. nextToken is "" once the
+// last page has been returned.
+func (mnt *mfsHandler) ListSnapshots(startingToken string, maxEntries int) (snapshots []*SnapshotInfo, nextToken string, err error) {
+	offset := 0
+	if startingToken != "" {
+		offset, err = strconv.Atoi(startingToken)
+		if err != nil || offset < 0 {
+			return nil, "", fmt.Errorf("ListSnapshots: invalid starting token %q", startingToken)
+		}
+	}
+
+	entries, err := os.ReadDir(mnt.hostSnapshotsDir())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, "", fmt.Errorf("ListSnapshots: %w", err)
+	}
+
+	var ids []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(e.Name(), ".json"))
+	}
+	sort.Strings(ids)
+
+	if offset > len(ids) {
+		return nil, "", fmt.Errorf("ListSnapshots: invalid starting token %q", startingToken)
+	}
+
+	end := len(ids)
+	if maxEntries > 0 && offset+maxEntries < end {
+		end = offset + maxEntries
+	}
+
+	snapshots = make([]*SnapshotInfo, 0, end-offset)
+	for _, id := range ids[offset:end] {
+		info, err := mnt.readSnapshotManifest(id)
+		if err != nil {
+			return nil, "", fmt.Errorf("ListSnapshots: %w", err)
+		}
+		snapshots = append(snapshots, info)
+	}
+
+	if end < len(ids) {
+		nextToken = strconv.Itoa(end)
+	}
+	return snapshots, nextToken, nil
+}
+
+// CloneFromSnapshot materializes volumeId's data as a copy of snapshotId,
+// for CreateVolume requests whose VolumeContentSource is a snapshot.
+func (mnt *mfsHandler) CloneFromSnapshot(volumeId, snapshotId string) error {
+	log.Infof("CloneFromSnapshot - volumeId: %s, snapshotId: %s", volumeId, snapshotId)
+
+	if err := mnt.cloneTree(mnt.HostPathToSnapshot(snapshotId), mnt.HostPathToVolume(volumeId)); err != nil {
+		return fmt.Errorf("CloneFromSnapshot: %w", err)
+	}
+	return nil
+}
+
+// CloneVolume materializes volumeId's data as a copy of sourceVolumeId,
+// for CreateVolume requests whose VolumeContentSource is another volume.
+func (mnt *mfsHandler) CloneVolume(volumeId, sourceVolumeId string) error {
+	log.Infof("CloneVolume - volumeId: %s, sourceVolumeId: %s", volumeId, sourceVolumeId)
+
+	if err := mnt.cloneTree(mnt.HostPathToVolume(sourceVolumeId), mnt.HostPathToVolume(volumeId)); err != nil {
+		return fmt.Errorf("CloneVolume: %w", err)
+	}
+	return nil
+}
+
+// CreateVolumeFromSnapshot clones snapshotId into a new volume volumeId
+// and, if size is nonzero, sets its quota. It is the snapshot-sourced
+// counterpart of CreateVolume, for CreateVolume requests whose
+// VolumeContentSource is a snapshot.
+func (mnt *mfsHandler) CreateVolumeFromSnapshot(volumeId, snapshotId string, size int64) (int64, error) {
+	defer mnt.locks.lockTwo(volumeId, snapshotId)()
+
+	if err := mnt.CloneFromSnapshot(volumeId, snapshotId); err != nil {
+		return 0, err
+	}
+	if size == 0 {
+		return 0, nil
+	}
+	return mnt.SetQuota(volumeId, size)
+}
+
+// CreateVolumeFromVolume clones sourceVolumeId into a new volume volumeId
+// and, if size is nonzero, sets its quota. It is the volume-sourced
+// counterpart of CreateVolume, for CreateVolume requests whose
+// VolumeContentSource is another volume.
+func (mnt *mfsHandler) CreateVolumeFromVolume(volumeId, sourceVolumeId string, size int64) (int64, error) {
+	defer mnt.locks.lockTwo(volumeId, sourceVolumeId)()
+
+	if err := mnt.CloneVolume(volumeId, sourceVolumeId); err != nil {
+		return 0, err
+	}
+	if size == 0 {
+		return 0, nil
+	}
+	return mnt.SetQuota(volumeId, size)
+}
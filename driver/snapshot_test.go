@@ -0,0 +1,162 @@
+/*
+   Copyright (c) 2023 Saglabs SA. All Rights Reserved.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestCreateSnapshot(t *testing.T) {
+	fakeExec := NewFakeExec()
+	mnt := newTestHandler(NewFakeMounter(), fakeExec)
+
+	if _, err := mnt.CreateSnapshot("vol-1", "snap-1"); err == nil {
+		t.Fatalf("expected error, GetQuota for the snapshot can't reach a real mfsmaster in this test")
+	}
+
+	if len(fakeExec.Calls) != 1 {
+		t.Fatalf("expected 1 exec call, got %d", len(fakeExec.Calls))
+	}
+	call := fakeExec.Calls[0]
+	if call.Name != createSnapshotCmd {
+		t.Errorf("expected command %q, got %q", createSnapshotCmd, call.Name)
+	}
+	if call.Dir != mnt.hostMountPath {
+		t.Errorf("expected Dir %q, got %q", mnt.hostMountPath, call.Dir)
+	}
+}
+
+func TestCreateSnapshot_ExecError(t *testing.T) {
+	fakeExec := NewFakeExec()
+	fakeExec.Results[createSnapshotCmd] = []FakeCmdResult{
+		{Output: []byte("can't create snapshot"), Err: errors.New("exit status 1")},
+	}
+	mnt := newTestHandler(NewFakeMounter(), fakeExec)
+
+	_, err := mnt.CreateSnapshot("vol-1", "snap-1")
+	if err == nil {
+		t.Fatal("expected error from failing mfsmakesnapshot call")
+	}
+}
+
+func TestDeleteSnapshot_SkipsRemoveWhenAlreadyGone(t *testing.T) {
+	fakeExec := NewFakeExec()
+	mnt := newTestHandler(NewFakeMounter(), fakeExec)
+
+	if err := mnt.DeleteSnapshot("snap-1"); err != nil {
+		t.Fatalf("DeleteSnapshot: unexpected error: %v", err)
+	}
+	if len(fakeExec.Calls) != 0 {
+		t.Fatalf("expected no mfsrmsnapshot call for a nonexistent snapshot, got %v", fakeExec.Calls)
+	}
+}
+
+func TestListSnapshots_EmptyWhenNoSnapshotsDir(t *testing.T) {
+	mnt := newTestHandler(NewFakeMounter(), NewFakeExec())
+	if err := os.RemoveAll(mnt.hostSnapshotsDir()); err != nil {
+		t.Fatalf("removing snapshots dir: %v", err)
+	}
+
+	snapshots, nextToken, err := mnt.ListSnapshots("", 0)
+	if err != nil {
+		t.Fatalf("ListSnapshots: unexpected error: %v", err)
+	}
+	if len(snapshots) != 0 || nextToken != "" {
+		t.Fatalf("expected no snapshots and no next token, got %v, %q", snapshots, nextToken)
+	}
+}
+
+func TestListSnapshots_PaginatesManifests(t *testing.T) {
+	mnt := newTestHandler(NewFakeMounter(), NewFakeExec())
+
+	for _, id := range []string{"snap-a", "snap-b", "snap-c"} {
+		if err := mnt.writeSnapshotManifest(&SnapshotInfo{SnapshotId: id, SourceVolumeId: "vol-1"}); err != nil {
+			t.Fatalf("writeSnapshotManifest(%s): %v", id, err)
+		}
+	}
+
+	page1, token1, err := mnt.ListSnapshots("", 2)
+	if err != nil {
+		t.Fatalf("ListSnapshots page 1: %v", err)
+	}
+	if len(page1) != 2 || page1[0].SnapshotId != "snap-a" || page1[1].SnapshotId != "snap-b" {
+		t.Fatalf("unexpected page 1: %+v", page1)
+	}
+	if token1 == "" {
+		t.Fatal("expected a next token after a partial page")
+	}
+
+	page2, token2, err := mnt.ListSnapshots(token1, 2)
+	if err != nil {
+		t.Fatalf("ListSnapshots page 2: %v", err)
+	}
+	if len(page2) != 1 || page2[0].SnapshotId != "snap-c" {
+		t.Fatalf("unexpected page 2: %+v", page2)
+	}
+	if token2 != "" {
+		t.Errorf("expected no next token after the last page, got %q", token2)
+	}
+}
+
+func TestListSnapshots_RejectsInvalidToken(t *testing.T) {
+	mnt := newTestHandler(NewFakeMounter(), NewFakeExec())
+
+	if _, _, err := mnt.ListSnapshots("not-a-number", 0); err == nil {
+		t.Fatal("expected error for a malformed starting token")
+	}
+}
+
+func TestCloneFromSnapshot(t *testing.T) {
+	fakeExec := NewFakeExec()
+	mnt := newTestHandler(NewFakeMounter(), fakeExec)
+
+	if err := mnt.CloneFromSnapshot("vol-2", "snap-1"); err != nil {
+		t.Fatalf("CloneFromSnapshot: unexpected error: %v", err)
+	}
+
+	if len(fakeExec.Calls) != 1 {
+		t.Fatalf("expected 1 exec call, got %d", len(fakeExec.Calls))
+	}
+	call := fakeExec.Calls[0]
+	if call.Name != createSnapshotCmd {
+		t.Errorf("expected command %q, got %q", createSnapshotCmd, call.Name)
+	}
+	wantArgs := []string{mnt.HostPathToSnapshot("snap-1"), mnt.HostPathToVolume("vol-2")}
+	if len(call.Args) != 2 || call.Args[0] != wantArgs[0] || call.Args[1] != wantArgs[1] {
+		t.Errorf("expected args %v, got %v", wantArgs, call.Args)
+	}
+}
+
+func TestCloneVolume(t *testing.T) {
+	fakeExec := NewFakeExec()
+	mnt := newTestHandler(NewFakeMounter(), fakeExec)
+
+	if err := mnt.CloneVolume("vol-2", "vol-1"); err != nil {
+		t.Fatalf("CloneVolume: unexpected error: %v", err)
+	}
+
+	if len(fakeExec.Calls) != 1 {
+		t.Fatalf("expected 1 exec call, got %d", len(fakeExec.Calls))
+	}
+	wantArgs := []string{mnt.HostPathToVolume("vol-1"), mnt.HostPathToVolume("vol-2")}
+	call := fakeExec.Calls[0]
+	if len(call.Args) != 2 || call.Args[0] != wantArgs[0] || call.Args[1] != wantArgs[1] {
+		t.Errorf("expected args %v, got %v", wantArgs, call.Args)
+	}
+}
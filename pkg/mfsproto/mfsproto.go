@@ -0,0 +1,361 @@
+/*
+   Copyright (c) 2023 Saglabs SA. All Rights Reserved.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package mfsproto is a minimal client for the MooseFS master
+// communication protocol: the same TCP wire protocol mfsmount and the
+// mfstools CLIs use to talk to mfsmaster. It implements only the two
+// packet families the CSI driver needs - FUSE_QUOTACONTROL and
+// FUSE_STATFS - so quota and filesystem-stats queries no longer require
+// forking mfsgetquota/mfssetquota and scraping their table output.
+package mfsproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Packet type identifiers, following the cltoma/matocl numbering used by
+// the MooseFS 3.x master protocol.
+const (
+	cltomaFuseQuotaControl = 400
+	matoclFuseQuotaControl = 401
+	cltomaFuseStatfs       = 402
+	matoclFuseStatfs       = 403
+)
+
+const packetHeaderLen = 8
+
+// ProtocolError is returned when mfsmaster answers with a different packet
+// type than the one we asked for, which normally means the master and
+// this client have drifted out of sync on protocol version.
+type ProtocolError struct {
+	Want uint32
+	Got  uint32
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("mfsproto: unexpected packet type %d in reply, wanted %d", e.Got, e.Want)
+}
+
+// mfsStatus maps the single-byte status codes mfsmaster sends back on
+// failure to human-readable text. Unrecognized codes still produce a
+// usable (if terse) error rather than failing to parse.
+var mfsStatus = map[byte]string{
+	0x00: "OK",
+	0x01: "operation not permitted",
+	0x02: "not a directory",
+	0x03: "no such file or directory",
+	0x05: "permission denied",
+	0x1A: "quota exceeded",
+	0x20: "directory not empty",
+}
+
+// StatusError is a typed error for a failed MooseFS operation, carrying
+// the raw status byte the master returned instead of a parsed substring.
+type StatusError struct {
+	Code byte
+}
+
+func (e *StatusError) Error() string {
+	if msg, ok := mfsStatus[e.Code]; ok {
+		return fmt.Sprintf("mfsmaster: %s (status 0x%02X)", msg, e.Code)
+	}
+	return fmt.Sprintf("mfsmaster: unknown status 0x%02X", e.Code)
+}
+
+// QuotaClass identifies one of the limits MooseFS tracks per quota
+// directory: inode count, logical byte length, storage size (length times
+// goal), and "real" size after replication/erasure-coding overhead.
+type QuotaClass uint8
+
+const (
+	QuotaInodes QuotaClass = iota
+	QuotaLength
+	QuotaSize
+	QuotaRealSize
+)
+
+// QuotaLimits holds the soft and hard limits for all four quota classes on
+// a single directory. A zero value means the corresponding limit is not
+// set, matching FUSE_QUOTACONTROL semantics.
+type QuotaLimits struct {
+	SoftInodes   uint32
+	HardInodes   uint32
+	SoftLength   uint64
+	HardLength   uint64
+	SoftSize     uint64
+	HardSize     uint64
+	SoftRealSize uint64
+	HardRealSize uint64
+}
+
+// FsStats is the decoded response to a FUSE_STATFS request.
+type FsStats struct {
+	TotalSpace  uint64
+	AvailSpace  uint64
+	TotalInodes uint32
+}
+
+// Client talks to a single mfsmaster over TCP. It does not pool
+// connections: every call dials fresh, sends one request, reads one
+// response, and closes the socket, mirroring the short-lived nature of the
+// exec.Command calls it replaces.
+type Client struct {
+	addr    string
+	timeout time.Duration
+}
+
+// NewClient returns a Client for the master at host:port. A timeout <= 0
+// defaults to 10s for both dialing and the request/response round trip.
+func NewClient(host string, port int, timeout time.Duration) *Client {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &Client{
+		addr:    net.JoinHostPort(host, fmt.Sprintf("%d", port)),
+		timeout: timeout,
+	}
+}
+
+func (c *Client) roundTrip(reqType uint32, payload []byte, wantReplyType uint32) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("mfsproto: dial %s: %w", c.addr, err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, fmt.Errorf("mfsproto: set deadline: %w", err)
+	}
+	if err := writePacket(conn, reqType, payload); err != nil {
+		return nil, err
+	}
+	reply, err := readPacket(bufio.NewReader(conn), wantReplyType)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func writePacket(w io.Writer, typ uint32, payload []byte) error {
+	header := make([]byte, packetHeaderLen)
+	binary.BigEndian.PutUint32(header[0:4], typ)
+	binary.BigEndian.PutUint32(header[4:8], uint32(len(payload)))
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("mfsproto: write packet header: %w", err)
+	}
+	if len(payload) == 0 {
+		return nil
+	}
+	if _, err := w.Write(payload); err != nil {
+		return fmt.Errorf("mfsproto: write packet payload: %w", err)
+	}
+	return nil
+}
+
+func readPacket(r *bufio.Reader, wantType uint32) ([]byte, error) {
+	header := make([]byte, packetHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("mfsproto: read packet header: %w", err)
+	}
+	typ := binary.BigEndian.Uint32(header[0:4])
+	length := binary.BigEndian.Uint32(header[4:8])
+	payload := make([]byte, length)
+	if length > 0 {
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, fmt.Errorf("mfsproto: read packet payload: %w", err)
+		}
+	}
+	if typ != wantType {
+		return nil, &ProtocolError{Want: wantType, Got: typ}
+	}
+	if len(payload) == 1 {
+		return nil, &StatusError{Code: payload[0]}
+	}
+	return payload, nil
+}
+
+// GetQuota fetches all quota limits set on mfsPath (a path relative to the
+// MooseFS root, as used elsewhere in this driver).
+func (c *Client) GetQuota(mfsPath string) (*QuotaLimits, error) {
+	payload, err := c.roundTrip(cltomaFuseQuotaControl, encodeQuotaControlRequest(mfsPath, nil, 0), matoclFuseQuotaControl)
+	if err != nil {
+		return nil, err
+	}
+	return decodeQuotaLimits(payload)
+}
+
+// quotaFieldFlags are the per-field presence bits of a FUSE_QUOTACONTROL
+// request: bit i set means field i (in the same soft/hard-per-class order
+// QuotaLimits and the wire format use) is being written by this request.
+// Setting only the bits for the class actually being changed tells
+// mfsmaster to leave every other class untouched; claiming all eight are
+// present (as a stale request once did) makes every SetQuota call reset
+// the other three classes to unset.
+const (
+	flagSoftInodes byte = 1 << iota
+	flagHardInodes
+	flagSoftLength
+	flagHardLength
+	flagSoftSize
+	flagHardSize
+	flagSoftRealSize
+	flagHardRealSize
+)
+
+// quotaClassFlags returns the wire presence bits for class's soft/hard
+// fields, or 0 for an unrecognized class.
+func quotaClassFlags(class QuotaClass) byte {
+	switch class {
+	case QuotaInodes:
+		return flagSoftInodes | flagHardInodes
+	case QuotaLength:
+		return flagSoftLength | flagHardLength
+	case QuotaSize:
+		return flagSoftSize | flagHardSize
+	case QuotaRealSize:
+		return flagSoftRealSize | flagHardRealSize
+	default:
+		return 0
+	}
+}
+
+// SetQuota sets the soft and hard limit for a single quota class on
+// mfsPath and returns the full, post-update set of limits the master
+// reports back. Only class's two fields are marked present on the wire;
+// the other three classes are left untouched on the master.
+func (c *Client) SetQuota(mfsPath string, class QuotaClass, soft, hard uint64) (*QuotaLimits, error) {
+	limits := &QuotaLimits{}
+	switch class {
+	case QuotaInodes:
+		limits.SoftInodes, limits.HardInodes = uint32(soft), uint32(hard)
+	case QuotaLength:
+		limits.SoftLength, limits.HardLength = soft, hard
+	case QuotaSize:
+		limits.SoftSize, limits.HardSize = soft, hard
+	case QuotaRealSize:
+		limits.SoftRealSize, limits.HardRealSize = soft, hard
+	default:
+		return nil, fmt.Errorf("mfsproto: unknown quota class %d", class)
+	}
+	payload, err := c.roundTrip(cltomaFuseQuotaControl, encodeQuotaControlRequest(mfsPath, limits, quotaClassFlags(class)), matoclFuseQuotaControl)
+	if err != nil {
+		return nil, err
+	}
+	return decodeQuotaLimits(payload)
+}
+
+// Statfs returns the total space, available space and total inode count
+// that mfsmaster reports for mfsPath in its FUSE_STATFS reply. The reply
+// carries no used or free inode counts.
+func (c *Client) Statfs(mfsPath string) (*FsStats, error) {
+	payload, err := c.roundTrip(cltomaFuseStatfs, encodeStatfsRequest(mfsPath), matoclFuseStatfs)
+	if err != nil {
+		return nil, err
+	}
+	return decodeFsStats(payload)
+}
+
+// encodeQuotaControlRequest builds a FUSE_QUOTACONTROL request for mfsPath.
+// flags marks which of limits' eight fields the master should actually
+// write (0 for a pure GetQuota read); limits may be nil iff flags is 0.
+// Inode counts are transmitted as 32-bit values, matching QuotaLimits'
+// uint32 Soft/HardInodes; every byte-size field is transmitted as a full
+// 64-bit value so multi-GiB (and larger) quotas survive the round trip.
+func encodeQuotaControlRequest(mfsPath string, limits *QuotaLimits, flags byte) []byte {
+	pathBytes := []byte(mfsPath)
+	buf := make([]byte, 4+len(pathBytes)+1+4*2+8*6)
+	off := 0
+	binary.BigEndian.PutUint32(buf[off:], uint32(len(pathBytes)))
+	off += 4
+	off += copy(buf[off:], pathBytes)
+
+	buf[off] = flags
+	off++
+
+	putU32 := func(v uint32) {
+		binary.BigEndian.PutUint32(buf[off:], v)
+		off += 4
+	}
+	putU64 := func(v uint64) {
+		binary.BigEndian.PutUint64(buf[off:], v)
+		off += 8
+	}
+	if limits == nil {
+		limits = &QuotaLimits{}
+	}
+	putU32(limits.SoftInodes)
+	putU32(limits.HardInodes)
+	putU64(limits.SoftLength)
+	putU64(limits.HardLength)
+	putU64(limits.SoftSize)
+	putU64(limits.HardSize)
+	putU64(limits.SoftRealSize)
+	putU64(limits.HardRealSize)
+	return buf
+}
+
+func decodeQuotaLimits(payload []byte) (*QuotaLimits, error) {
+	const wantLen = 1 + 4*2 + 8*6
+	if len(payload) < wantLen {
+		return nil, fmt.Errorf("mfsproto: quota control reply too short: got %d bytes, want >= %d", len(payload), wantLen)
+	}
+	off := 1 // skip flags byte
+	readU32 := func() uint32 {
+		v := binary.BigEndian.Uint32(payload[off:])
+		off += 4
+		return v
+	}
+	readU64 := func() uint64 {
+		v := binary.BigEndian.Uint64(payload[off:])
+		off += 8
+		return v
+	}
+	return &QuotaLimits{
+		SoftInodes:   readU32(),
+		HardInodes:   readU32(),
+		SoftLength:   readU64(),
+		HardLength:   readU64(),
+		SoftSize:     readU64(),
+		HardSize:     readU64(),
+		SoftRealSize: readU64(),
+		HardRealSize: readU64(),
+	}, nil
+}
+
+func encodeStatfsRequest(mfsPath string) []byte {
+	pathBytes := []byte(mfsPath)
+	buf := make([]byte, 4+len(pathBytes))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(pathBytes)))
+	copy(buf[4:], pathBytes)
+	return buf
+}
+
+func decodeFsStats(payload []byte) (*FsStats, error) {
+	const wantLen = 8 + 8 + 4
+	if len(payload) < wantLen {
+		return nil, fmt.Errorf("mfsproto: statfs reply too short: got %d bytes, want >= %d", len(payload), wantLen)
+	}
+	return &FsStats{
+		TotalSpace:  binary.BigEndian.Uint64(payload[0:8]),
+		AvailSpace:  binary.BigEndian.Uint64(payload[8:16]),
+		TotalInodes: binary.BigEndian.Uint32(payload[16:20]),
+	}, nil
+}
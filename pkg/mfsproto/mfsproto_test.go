@@ -0,0 +1,119 @@
+/*
+   Copyright (c) 2023 Saglabs SA. All Rights Reserved.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mfsproto
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// quotaReplyPayload simulates mfsmaster's FUSE_QUOTACONTROL reply by
+// building a request with encodeQuotaControlRequest and stripping its
+// path prefix, since both share the same flags+fields tail layout.
+func quotaReplyPayload(limits *QuotaLimits, flags byte) []byte {
+	const mfsPath = "plugin-data/volumes/vol-1"
+	req := encodeQuotaControlRequest(mfsPath, limits, flags)
+	return req[4+len(mfsPath):]
+}
+
+func TestQuotaLimitsRoundTrip_SurvivesLargeSizes(t *testing.T) {
+	const tenGiB = 10 * 1024 * 1024 * 1024 // > uint32 max, the bug this guards against
+	want := &QuotaLimits{
+		SoftInodes:   1000,
+		HardInodes:   2000,
+		SoftLength:   tenGiB,
+		HardLength:   tenGiB + 1,
+		SoftSize:     tenGiB + 2,
+		HardSize:     tenGiB + 3,
+		SoftRealSize: tenGiB + 4,
+		HardRealSize: tenGiB + 5,
+	}
+
+	got, err := decodeQuotaLimits(quotaReplyPayload(want, 0xFF))
+	if err != nil {
+		t.Fatalf("decodeQuotaLimits: unexpected error: %v", err)
+	}
+	if *got != *want {
+		t.Errorf("round trip mismatch:\n got  %+v\n want %+v", got, want)
+	}
+}
+
+func TestQuotaClassFlags_OnlyTargetClassBits(t *testing.T) {
+	cases := []struct {
+		class QuotaClass
+		want  byte
+	}{
+		{QuotaInodes, flagSoftInodes | flagHardInodes},
+		{QuotaLength, flagSoftLength | flagHardLength},
+		{QuotaSize, flagSoftSize | flagHardSize},
+		{QuotaRealSize, flagSoftRealSize | flagHardRealSize},
+	}
+	for _, tc := range cases {
+		if got := quotaClassFlags(tc.class); got != tc.want {
+			t.Errorf("quotaClassFlags(%d) = 0x%02X, want 0x%02X", tc.class, got, tc.want)
+		}
+	}
+
+	allFlags := flagSoftInodes | flagHardInodes | flagSoftLength | flagHardLength |
+		flagSoftSize | flagHardSize | flagSoftRealSize | flagHardRealSize
+	for _, tc := range cases {
+		if tc.want&^allFlags != 0 {
+			t.Errorf("quotaClassFlags(%d) = 0x%02X sets bits outside the known eight", tc.class, tc.want)
+		}
+		for _, other := range cases {
+			if other.class == tc.class {
+				continue
+			}
+			if tc.want&other.want != 0 {
+				t.Errorf("quotaClassFlags(%d) and quotaClassFlags(%d) overlap: 0x%02X & 0x%02X", tc.class, other.class, tc.want, other.want)
+			}
+		}
+	}
+}
+
+func TestGetQuota_RequestsNoFieldsPresent(t *testing.T) {
+	const mfsPath = "plugin-data/volumes/vol-1"
+	req := encodeQuotaControlRequest(mfsPath, nil, 0)
+	flags := req[4+len(mfsPath)]
+	if flags != 0 {
+		t.Errorf("expected a pure GetQuota request to claim no fields present, got flags 0x%02X", flags)
+	}
+}
+
+func TestFsStatsRoundTrip(t *testing.T) {
+	const mfsPath = "plugin-data/volumes/vol-1"
+	req := encodeStatfsRequest(mfsPath)
+
+	gotPathLen := len(req) - 4
+	if gotPathLen != len(mfsPath) {
+		t.Fatalf("encodeStatfsRequest: expected path length %d, got %d", len(mfsPath), gotPathLen)
+	}
+
+	want := &FsStats{TotalSpace: 20 * 1024 * 1024 * 1024, AvailSpace: 5 * 1024 * 1024 * 1024, TotalInodes: 123456}
+	payload := make([]byte, 20)
+	binary.BigEndian.PutUint64(payload[0:8], want.TotalSpace)
+	binary.BigEndian.PutUint64(payload[8:16], want.AvailSpace)
+	binary.BigEndian.PutUint32(payload[16:20], want.TotalInodes)
+
+	got, err := decodeFsStats(payload)
+	if err != nil {
+		t.Fatalf("decodeFsStats: unexpected error: %v", err)
+	}
+	if *got != *want {
+		t.Errorf("round trip mismatch:\n got  %+v\n want %+v", got, want)
+	}
+}